@@ -0,0 +1,137 @@
+// Package procfs provides small /proc-scraping helpers for mapping
+// between processes and the namespaces they reside in, used by libnetwork
+// to attach to namespaces it did not itself create.
+package procfs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// procRoot is where the real kernel mounts procfs. The traversal logic
+// below is parameterized on a root path rather than hardcoding this
+// constant, so tests can point it at a synthetic /proc-shaped directory
+// instead.
+const procRoot = "/proc"
+
+// Children returns the direct child PIDs of pid, as reported by the
+// kernel's /proc/<pid>/task/*/children interface.
+func Children(pid int) ([]int, error) {
+	return children(procRoot, pid)
+}
+
+func children(root string, pid int) ([]int, error) {
+	matches, err := filepath.Glob(fmt.Sprintf("%s/%d/task/*/children", root, pid))
+	if err != nil {
+		return nil, err
+	}
+
+	var result []int
+	for _, path := range matches {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			// The task may have exited since Glob ran; that's fine.
+			continue
+		}
+		for _, field := range strings.Fields(string(data)) {
+			childPid, err := strconv.Atoi(field)
+			if err != nil {
+				continue
+			}
+			result = append(result, childPid)
+		}
+	}
+	return result, nil
+}
+
+// netNsInode returns the inode backing <root>/<pid>/ns/net, which the
+// kernel sets to the same value for every process sharing that network
+// namespace.
+func netNsInode(root string, pid int) (uint64, error) {
+	return inode(fmt.Sprintf("%s/%d/ns/net", root, pid))
+}
+
+func inode(path string) (uint64, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return 0, err
+	}
+	return st.Ino, nil
+}
+
+// FindDescendantInNamespace walks pid and its descendants, recursively
+// following /proc/<pid>/task/*/children, looking for the first one whose
+// network namespace matches the one backing wantNetNsPath (typically a
+// sandbox's key file). This is needed because the PID handed to an
+// integration is very often a shim/wrapper that has already re-exec'd
+// into a different namespace than its children.
+func FindDescendantInNamespace(pid int, wantNetNsPath string) (int, error) {
+	return findDescendantInNamespace(procRoot, pid, wantNetNsPath)
+}
+
+func findDescendantInNamespace(root string, pid int, wantNetNsPath string) (int, error) {
+	wantInode, err := inode(wantNetNsPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat %s: %v", wantNetNsPath, err)
+	}
+
+	visited := map[int]bool{}
+	queue := []int{pid}
+	for len(queue) > 0 {
+		candidate := queue[0]
+		queue = queue[1:]
+		if visited[candidate] {
+			continue
+		}
+		visited[candidate] = true
+
+		if ino, err := netNsInode(root, candidate); err == nil && ino == wantInode {
+			return candidate, nil
+		}
+
+		kids, err := children(root, candidate)
+		if err != nil {
+			continue
+		}
+		queue = append(queue, kids...)
+	}
+
+	return 0, fmt.Errorf("no descendant of pid %d found in namespace %s", pid, wantNetNsPath)
+}
+
+// FindPidInNamespace scans every process currently listed under /proc and
+// returns the first whose network namespace matches the one backing
+// wantNetNsPath. Unlike FindDescendantInNamespace it isn't limited to a
+// particular process tree, which is what lets a sandbox reverse-map
+// itself back to a live PID regardless of who created it.
+func FindPidInNamespace(wantNetNsPath string) (int, error) {
+	return findPidInNamespace(procRoot, wantNetNsPath)
+}
+
+func findPidInNamespace(root, wantNetNsPath string) (int, error) {
+	wantInode, err := inode(wantNetNsPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat %s: %v", wantNetNsPath, err)
+	}
+
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		if ino, err := netNsInode(root, pid); err == nil && ino == wantInode {
+			return pid, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no live process found in namespace %s", wantNetNsPath)
+}
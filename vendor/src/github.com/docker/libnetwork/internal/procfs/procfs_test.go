@@ -0,0 +1,192 @@
+package procfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writePid creates <root>/<pid>/ns/net as a file uniquely identifying the
+// namespace nsFile (via a hardlink, so it shares its inode), and, if kids
+// is non-empty, <root>/<pid>/task/<pid>/children listing them.
+func writePid(t *testing.T, root string, pid int, nsFile string, kids ...int) {
+	t.Helper()
+
+	nsDir := filepath.Join(root, fmt.Sprintf("%d", pid), "ns")
+	if err := os.MkdirAll(nsDir, 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := os.Link(nsFile, filepath.Join(nsDir, "net")); err != nil {
+		t.Fatalf("Link: %v", err)
+	}
+
+	taskDir := filepath.Join(root, fmt.Sprintf("%d", pid), "task", fmt.Sprintf("%d", pid))
+	if err := os.MkdirAll(taskDir, 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	data := ""
+	for _, kid := range kids {
+		data += fmt.Sprintf("%d ", kid)
+	}
+	if err := os.WriteFile(filepath.Join(taskDir, "children"), []byte(data), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+}
+
+// newNsFile creates a fresh, uniquely-inoded file standing in for a
+// network namespace, outside of root (mirroring how a real netns key file
+// lives outside /proc).
+func newNsFile(t *testing.T, dir, name string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return path
+}
+
+func TestChildren(t *testing.T) {
+	root := t.TempDir()
+	nsFile := newNsFile(t, t.TempDir(), "ns-a")
+	writePid(t, root, 1, nsFile, 2, 3)
+
+	kids, err := children(root, 1)
+	if err != nil {
+		t.Fatalf("children: %v", err)
+	}
+
+	got := map[int]bool{}
+	for _, k := range kids {
+		got[k] = true
+	}
+	if !got[2] || !got[3] || len(got) != 2 {
+		t.Errorf("children(1) = %v, want [2 3]", kids)
+	}
+}
+
+func TestChildrenNoTasksIsNotAnError(t *testing.T) {
+	root := t.TempDir()
+	kids, err := children(root, 999)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(kids) != 0 {
+		t.Errorf("expected no children for an unknown pid, got %v", kids)
+	}
+}
+
+func TestFindDescendantInNamespaceFindsSelf(t *testing.T) {
+	root := t.TempDir()
+	wantNs := newNsFile(t, t.TempDir(), "ns-a")
+	writePid(t, root, 1, wantNs)
+
+	pid, err := findDescendantInNamespace(root, 1, wantNs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pid != 1 {
+		t.Errorf("pid = %d, want 1", pid)
+	}
+}
+
+func TestFindDescendantInNamespaceWalksChildren(t *testing.T) {
+	root := t.TempDir()
+	nsDir := t.TempDir()
+	shimNs := newNsFile(t, nsDir, "shim-ns")
+	wantNs := newNsFile(t, nsDir, "container-ns")
+
+	// pid 1 is a shim, re-exec'd into its own namespace; its child (pid 2)
+	// is the one that actually lives in the namespace we're looking for;
+	// pid 2 in turn has its own child, pid 3, in the same namespace.
+	writePid(t, root, 1, shimNs, 2)
+	writePid(t, root, 2, wantNs, 3)
+	writePid(t, root, 3, wantNs)
+
+	pid, err := findDescendantInNamespace(root, 1, wantNs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pid != 2 {
+		t.Errorf("pid = %d, want 2 (the first descendant found in the namespace)", pid)
+	}
+}
+
+func TestFindDescendantInNamespaceNotFound(t *testing.T) {
+	root := t.TempDir()
+	nsDir := t.TempDir()
+	shimNs := newNsFile(t, nsDir, "shim-ns")
+	otherNs := newNsFile(t, nsDir, "other-ns")
+
+	writePid(t, root, 1, shimNs)
+
+	if _, err := findDescendantInNamespace(root, 1, otherNs); err == nil {
+		t.Error("expected an error when no descendant is in the wanted namespace")
+	}
+}
+
+func TestFindDescendantInNamespaceToleratesCycles(t *testing.T) {
+	root := t.TempDir()
+	nsDir := t.TempDir()
+	ns := newNsFile(t, nsDir, "ns-a")
+	wantNs := newNsFile(t, nsDir, "ns-b")
+
+	// pid 1 and pid 2 each (bogusly) list each other as a child; neither
+	// is in the wanted namespace. The BFS must still terminate.
+	writePid(t, root, 1, ns, 2)
+	writePid(t, root, 2, ns, 1)
+
+	if _, err := findDescendantInNamespace(root, 1, wantNs); err == nil {
+		t.Error("expected an error, not an infinite loop, when no pid matches")
+	}
+}
+
+func TestFindPidInNamespace(t *testing.T) {
+	root := t.TempDir()
+	nsDir := t.TempDir()
+	wantNs := newNsFile(t, nsDir, "ns-a")
+	otherNs := newNsFile(t, nsDir, "ns-b")
+
+	writePid(t, root, 10, otherNs)
+	writePid(t, root, 20, wantNs)
+
+	pid, err := findPidInNamespace(root, wantNs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pid != 20 {
+		t.Errorf("pid = %d, want 20", pid)
+	}
+}
+
+func TestFindPidInNamespaceNotFound(t *testing.T) {
+	root := t.TempDir()
+	nsDir := t.TempDir()
+	otherNs := newNsFile(t, nsDir, "ns-a")
+	wantNs := newNsFile(t, nsDir, "ns-b")
+
+	writePid(t, root, 10, otherNs)
+
+	if _, err := findPidInNamespace(root, wantNs); err == nil {
+		t.Error("expected an error when no process is in the wanted namespace")
+	}
+}
+
+func TestFindPidInNamespaceIgnoresNonPidEntries(t *testing.T) {
+	root := t.TempDir()
+	nsDir := t.TempDir()
+	wantNs := newNsFile(t, nsDir, "ns-a")
+	writePid(t, root, 20, wantNs)
+
+	if err := os.MkdirAll(filepath.Join(root, "self"), 0755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+
+	pid, err := findPidInNamespace(root, wantNs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pid != 20 {
+		t.Errorf("pid = %d, want 20", pid)
+	}
+}
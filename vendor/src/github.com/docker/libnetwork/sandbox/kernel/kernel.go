@@ -0,0 +1,15 @@
+// Package kernel provides helpers for tuning the kernel "knobs" (sysctls)
+// visible inside a network namespace.
+package kernel
+
+// OSValue represents a sysctl value that can be applied to a network
+// namespace, subject to CheckFn approving the overwrite.
+type OSValue struct {
+	// Value is the value to write to the sysctl.
+	Value string
+	// CheckFn, if non-nil, is consulted with the sysctl's current and
+	// desired values before writing; the write is skipped if it
+	// returns false. This is used to avoid clobbering a value an
+	// operator has deliberately tuned away from the kernel default.
+	CheckFn func(current, desired string) bool
+}
@@ -0,0 +1,55 @@
+package kernel
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// path converts a dotted sysctl key, e.g. "net.ipv4.vs.conn_reuse_mode",
+// into its /proc/sys path.
+func path(key string) string {
+	return filepath.Join("/proc/sys", strings.Replace(key, ".", "/", -1))
+}
+
+// ApplyOSValue writes value.Value to the sysctl named key, unless
+// value.CheckFn is set and returns false for the sysctl's current value.
+// The caller is responsible for making sure this runs in whatever network
+// namespace the sysctl should apply to.
+func ApplyOSValue(key string, value OSValue) error {
+	if value.CheckFn != nil {
+		current, err := readValue(key)
+		if err != nil {
+			return err
+		}
+		if !value.CheckFn(current, value.Value) {
+			return nil
+		}
+	}
+	return writeValue(key, value.Value)
+}
+
+func readValue(key string) (string, error) {
+	data, err := ioutil.ReadFile(path(key))
+	if err != nil {
+		return "", fmt.Errorf("failed to read sysctl %s: %v", key, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func writeValue(key, value string) error {
+	if err := ioutil.WriteFile(path(key), []byte(value), 0644); err != nil {
+		return fmt.Errorf("failed to set sysctl %s=%s: %v", key, value, err)
+	}
+	return nil
+}
+
+// OnlyIfDefault returns a CheckFn that only allows the write when the
+// sysctl's current value still matches the kernel's own default,
+// preventing an operator-tuned value from being clobbered.
+func OnlyIfDefault(kernelDefault string) func(current, desired string) bool {
+	return func(current, desired string) bool {
+		return current == kernelDefault
+	}
+}
@@ -0,0 +1,24 @@
+package kernel
+
+import "testing"
+
+func TestOnlyIfDefaultAllowsWhenCurrentMatchesDefault(t *testing.T) {
+	check := OnlyIfDefault("0")
+	if !check("0", "1") {
+		t.Error("expected the write to be allowed when current equals the kernel default")
+	}
+}
+
+func TestOnlyIfDefaultBlocksWhenCurrentDiffersFromDefault(t *testing.T) {
+	check := OnlyIfDefault("0")
+	if check("1", "2") {
+		t.Error("expected the write to be blocked when current has already been tuned away from the default")
+	}
+}
+
+func TestOnlyIfDefaultIgnoresDesired(t *testing.T) {
+	check := OnlyIfDefault("0")
+	if !check("0", "anything") {
+		t.Error("expected the decision to depend only on current vs. kernelDefault, not on desired")
+	}
+}
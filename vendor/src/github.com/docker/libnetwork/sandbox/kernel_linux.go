@@ -0,0 +1,115 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/sandbox/kernel"
+	"github.com/vishvananda/netns"
+)
+
+// Option changes the set of kernel knobs a Sandbox applies to its
+// namespace at creation time.
+type Option func(ns *networkNamespace)
+
+// GenerateSysctlOption adds or overrides a single sysctl knob, on top of
+// the sandbox's default set. Unlike the defaults, a knob added this way
+// is considered user-specified: if it fails to apply, sandbox creation
+// fails, even if it happens to share its key with one of the defaults.
+func GenerateSysctlOption(key string, value kernel.OSValue) Option {
+	return func(ns *networkNamespace) {
+		ns.sysctls[key] = value
+		ns.userSysctls[key] = true
+	}
+}
+
+// defaultSysctls holds the knobs every sandbox applies unless told
+// otherwise. They target IPVS-heavy workloads, where the stock kernel
+// defaults interact badly with the short-lived connections a load
+// balancer sees. CheckFn guards each one so that an operator who already
+// tuned the host away from its kernel default is left alone.
+func defaultSysctls() map[string]kernel.OSValue {
+	return map[string]kernel.OSValue{
+		"net.ipv4.vs.conn_reuse_mode": {
+			Value:   "0",
+			CheckFn: kernel.OnlyIfDefault("1"),
+		},
+		"net.ipv4.vs.expire_nodest_conn": {
+			Value:   "1",
+			CheckFn: kernel.OnlyIfDefault("0"),
+		},
+		"net.ipv4.vs.expire_quiescent_template": {
+			Value:   "1",
+			CheckFn: kernel.OnlyIfDefault("0"),
+		},
+	}
+}
+
+// applySysctls applies n.sysctls inside n's namespace. Failures on the
+// default knobs are logged and ignored, since they're best-effort tuning;
+// failures on any knob added via an Option are returned, since the caller
+// explicitly asked for it and sandbox creation should fail rather than
+// silently skip it. Provenance is tracked per-key in n.userSysctls rather
+// than inferred from whether the key also happens to appear in
+// defaultSysctls(), so a caller overriding one of those same three keys
+// via an Option is still treated as user-specified.
+func (n *networkNamespace) applySysctls() error {
+	return n.invoke(func() error {
+		for key, value := range n.sysctls {
+			err := kernel.ApplyOSValue(key, value)
+			if err == nil {
+				continue
+			}
+			if !n.userSysctls[key] {
+				logrus.Warnf("sandbox %s: failed to apply default sysctl %s: %v", n.path, key, err)
+				continue
+			}
+			return fmt.Errorf("sandbox %s: failed to apply sysctl %s: %v", n.path, key, err)
+		}
+		return nil
+	})
+}
+
+// disableIPv6OnInterface turns off IPv6 on an interface that was added to
+// the sandbox without an IPv6 address of its own; a dangling IPv6 link
+// local address on an otherwise v4-only interface is rarely wanted and
+// costs link-local DAD traffic for nothing. This is best-effort.
+func (n *networkNamespace) disableIPv6OnInterface(ifaceName string) {
+	key := fmt.Sprintf("net.ipv6.conf.%s.disable_ipv6", ifaceName)
+	err := n.invoke(func() error {
+		return kernel.ApplyOSValue(key, kernel.OSValue{Value: "1"})
+	})
+	if err != nil {
+		logrus.Warnf("sandbox %s: failed to disable IPv6 on %s: %v", n.path, ifaceName, err)
+	}
+}
+
+// invoke runs fn with the calling goroutine's OS thread temporarily
+// switched into n's namespace. It exists only for operations like sysctl
+// writes that have no netlink equivalent and so can't go through
+// n.nlHandle; everything else should use the handle instead.
+func (n *networkNamespace) invoke(fn func() error) error {
+	origns, err := netns.Get()
+	if err != nil {
+		return fmt.Errorf("failed to get current network namespace: %v", err)
+	}
+	defer origns.Close()
+
+	f, err := os.OpenFile(n.path, os.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open network namespace %q: %v", n.path, err)
+	}
+	defer f.Close()
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err := netns.Set(netns.NsHandle(f.Fd())); err != nil {
+		return fmt.Errorf("failed to enter network namespace %q: %v", n.path, err)
+	}
+	defer netns.Set(origns)
+
+	return fn()
+}
@@ -0,0 +1,342 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/sandbox/kernel"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// networkNamespace is a linux implementation of the Sandbox interface. It
+// represents a linux network namespace, bound to a netlink.Handle obtained
+// at creation time via netlink.NewHandleAt, so that every interface/route/
+// neighbor operation this sandbox performs talks to the right netns
+// without ever switching the calling goroutine's namespace. That in turn
+// means AddInterface/RemoveInterface are safe to call concurrently from
+// different goroutines.
+type networkNamespace struct {
+	mu       sync.Mutex
+	path     string
+	sinfo    *Info
+	nlHandle *netlink.Handle
+	sysctls  map[string]kernel.OSValue
+	// userSysctls tracks, by key, which entries in sysctls were added by a
+	// caller-supplied Option rather than by defaultSysctls(). applySysctls
+	// uses it to decide whether a write failure is best-effort or fatal.
+	userSysctls map[string]bool
+}
+
+// NewSandbox provides a new Sandbox instance created in an os specific way
+// provided a key which uniquely identifies the sandbox. Any Option is
+// applied on top of the sandbox's default kernel knobs before returning.
+func NewSandbox(key string, osCreate bool, options ...Option) (Sandbox, error) {
+	if err := createNetworkNamespace(key, osCreate); err != nil {
+		return nil, err
+	}
+
+	n, err := attachToNamespace(key)
+	if err != nil {
+		return nil, err
+	}
+
+	n.sysctls = defaultSysctls()
+	n.userSysctls = make(map[string]bool)
+	for _, opt := range options {
+		opt(n)
+	}
+	if err := n.applySysctls(); err != nil {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+// attachToNamespace opens the netns at path and returns a networkNamespace
+// holding a handle bound to it.
+func attachToNamespace(path string) (*networkNamespace, error) {
+	sboxNs, err := netns.GetFromPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get network namespace from %q: %v", path, err)
+	}
+	defer sboxNs.Close()
+
+	nlHandle, err := netlink.NewHandleAt(sboxNs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain a netlink handle for namespace %q: %v", path, err)
+	}
+
+	return &networkNamespace{path: path, sinfo: &Info{}, nlHandle: nlHandle}, nil
+}
+
+// createNetworkNamespace creates a new network namespace and bind mounts
+// it at key, so that it survives as long as the mount does, even after
+// every process that joined it exits.
+func createNetworkNamespace(key string, osCreate bool) error {
+	origns, err := netns.Get()
+	if err != nil {
+		return fmt.Errorf("failed to get original network namespace: %v", err)
+	}
+	defer origns.Close()
+
+	if err := createNamespaceFile(key); err != nil {
+		return err
+	}
+
+	// The namespace switch below is confined to this short-lived setup
+	// path. Every subsequent operation against the sandbox goes through
+	// its own netlink.Handle, not through a namespace-switched thread.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	defer netns.Set(origns)
+
+	var newNs netns.NsHandle
+	if osCreate {
+		newNs, err = netns.NewNamed(filepath.Base(key))
+	} else {
+		newNs, err = netns.New()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create new network namespace: %v", err)
+	}
+	defer newNs.Close()
+
+	procNet := fmt.Sprintf("/proc/self/fd/%d", int(newNs))
+	if err := syscall.Mount(procNet, key, "", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("failed to bind mount network namespace %q at %q: %v", procNet, key, err)
+	}
+
+	return nil
+}
+
+func createNamespaceFile(key string) error {
+	if err := os.MkdirAll(filepath.Dir(key), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(key, os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil
+		}
+		return err
+	}
+	return f.Close()
+}
+
+func (n *networkNamespace) Key() string {
+	return n.path
+}
+
+func (n *networkNamespace) Info() *Info {
+	return n.sinfo
+}
+
+func (n *networkNamespace) Interfaces() []*Interface {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.sinfo.Interfaces
+}
+
+func (n *networkNamespace) AddInterface(i *Interface) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	link, err := n.nlHandle.LinkByName(i.SrcName)
+	if err != nil {
+		return fmt.Errorf("failed to get link %q: %v", i.SrcName, err)
+	}
+
+	if err := n.nlHandle.LinkSetDown(link); err != nil {
+		return fmt.Errorf("failed to set link %q down: %v", i.SrcName, err)
+	}
+
+	dstName := i.DstName
+	if dstName == "" {
+		dstName = i.SrcName
+	}
+	if err := n.nlHandle.LinkSetName(link, dstName); err != nil {
+		return fmt.Errorf("failed to rename link %q to %q: %v", i.SrcName, dstName, err)
+	}
+
+	if i.Addresses != nil {
+		if err := n.nlHandle.AddrAdd(link, &netlink.Addr{IPNet: i.Addresses}); err != nil {
+			return fmt.Errorf("failed to add address %v to %q: %v", i.Addresses, dstName, err)
+		}
+	}
+	if i.AddressIPv6 != nil {
+		if err := n.nlHandle.AddrAdd(link, &netlink.Addr{IPNet: i.AddressIPv6}); err != nil {
+			return fmt.Errorf("failed to add IPv6 address %v to %q: %v", i.AddressIPv6, dstName, err)
+		}
+	} else {
+		n.disableIPv6OnInterface(dstName)
+	}
+
+	if err := n.nlHandle.LinkSetUp(link); err != nil {
+		return fmt.Errorf("failed to set link %q up: %v", dstName, err)
+	}
+
+	n.sinfo.Interfaces = append(n.sinfo.Interfaces, i)
+	return nil
+}
+
+func (n *networkNamespace) RemoveInterface(i *Interface) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	name := i.DstName
+	if name == "" {
+		name = i.SrcName
+	}
+	link, err := n.nlHandle.LinkByName(name)
+	if err != nil {
+		return fmt.Errorf("failed to get link %q: %v", name, err)
+	}
+	if err := n.nlHandle.LinkDel(link); err != nil {
+		return fmt.Errorf("failed to remove link %q: %v", name, err)
+	}
+
+	for idx, iface := range n.sinfo.Interfaces {
+		if iface == i {
+			n.sinfo.Interfaces = append(n.sinfo.Interfaces[:idx], n.sinfo.Interfaces[idx+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (n *networkNamespace) Destroy() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.nlHandle != nil {
+		n.nlHandle.Delete()
+	}
+
+	addToGarbagePaths(n.path)
+	return nil
+}
+
+// scanInterfaceStats extracts the statistics for ifName out of the
+// contents of /proc/net/dev (passed in as data) into i.
+func scanInterfaceStats(data, ifName string, i *InterfaceStatistics) error {
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 17 {
+			continue
+		}
+		if strings.TrimSuffix(fields[0], ":") != ifName {
+			continue
+		}
+
+		var err error
+		if i.RxBytes, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+			return err
+		}
+		if i.RxPackets, err = strconv.ParseUint(fields[2], 10, 64); err != nil {
+			return err
+		}
+		if i.RxErrors, err = strconv.ParseUint(fields[3], 10, 64); err != nil {
+			return err
+		}
+		if i.RxDropped, err = strconv.ParseUint(fields[4], 10, 64); err != nil {
+			return err
+		}
+		if i.TxBytes, err = strconv.ParseUint(fields[9], 10, 64); err != nil {
+			return err
+		}
+		if i.TxPackets, err = strconv.ParseUint(fields[10], 10, 64); err != nil {
+			return err
+		}
+		if i.TxErrors, err = strconv.ParseUint(fields[11], 10, 64); err != nil {
+			return err
+		}
+		if i.TxDropped, err = strconv.ParseUint(fields[12], 10, 64); err != nil {
+			return err
+		}
+		return nil
+	}
+	return fmt.Errorf("could not find interface %s in /proc/net/dev data", ifName)
+}
+
+// garbagePathMap/gpmLock/gpmCleanupPeriod/gpmChan implement a periodic
+// reaper for sandbox paths that have been Destroy()ed: the bind mount is
+// lazily detached and the path removed at most gpmCleanupPeriod after
+// Destroy(), rather than synchronously, since a still-exiting process may
+// briefly keep the namespace busy.
+var (
+	garbagePathMap   = make(map[string]bool)
+	gpmLock          sync.Mutex
+	gpmCleanupPeriod = 60 * time.Second
+	gpmChan          = make(chan chan struct{})
+)
+
+func init() {
+	go removeGarbagePaths()
+}
+
+// GC triggers a synchronous garbage collection pass of destroyed sandbox
+// paths. Tests lower gpmCleanupPeriod and use GC to avoid waiting it out.
+func GC() {
+	gpmLock.Lock()
+	empty := len(garbagePathMap) == 0
+	gpmLock.Unlock()
+	if empty {
+		return
+	}
+
+	waitChan := make(chan struct{})
+	gpmChan <- waitChan
+	<-waitChan
+}
+
+func addToGarbagePaths(path string) {
+	gpmLock.Lock()
+	garbagePathMap[path] = true
+	gpmLock.Unlock()
+}
+
+func removeFromGarbagePaths(path string) {
+	gpmLock.Lock()
+	delete(garbagePathMap, path)
+	gpmLock.Unlock()
+}
+
+func removeGarbagePaths() {
+	for {
+		gpmLock.Lock()
+		var paths []string
+		for p := range garbagePathMap {
+			paths = append(paths, p)
+		}
+		gpmLock.Unlock()
+
+		for _, path := range paths {
+			if err := syscall.Unmount(path, syscall.MNT_DETACH); err != nil && err != syscall.EINVAL {
+				logrus.Debugf("unmount of sandbox path %s failed: %v", path, err)
+			}
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				logrus.Debugf("removal of sandbox path %s failed: %v", path, err)
+			}
+			removeFromGarbagePaths(path)
+		}
+
+		gpmLock.Lock()
+		period := gpmCleanupPeriod
+		gpmLock.Unlock()
+
+		select {
+		case <-time.After(period):
+		case c := <-gpmChan:
+			c <- struct{}{}
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package sandbox
+
+import (
+	"fmt"
+	"path/filepath"
+	"syscall"
+
+	"github.com/docker/libnetwork/internal/procfs"
+)
+
+// prefix is the directory namespace files bind-mounted by
+// NewSandboxFromPid are placed under.
+const prefix = "/var/run/docker/netns"
+
+// NewSandboxFromPid adopts the network namespace of an existing process
+// into a Sandbox, for integrations (CNI plugins, sidecar injectors, debug
+// tooling) that create containers/namespaces outside libnetwork and then
+// need libnetwork machinery to manage them. The namespace is bind-mounted
+// under prefix so it survives pid exiting, exactly like one libnetwork
+// created itself.
+func NewSandboxFromPid(pid int) (Sandbox, error) {
+	key := filepath.Join(prefix, fmt.Sprintf("adopted-%d", pid))
+
+	if err := createNamespaceFile(key); err != nil {
+		return nil, err
+	}
+
+	nsPath := fmt.Sprintf("/proc/%d/ns/net", pid)
+	if err := syscall.Mount(nsPath, key, "", syscall.MS_BIND, ""); err != nil {
+		return nil, fmt.Errorf("failed to bind mount network namespace of pid %d at %q: %v", pid, key, err)
+	}
+
+	return attachToNamespace(key)
+}
+
+// GetPidForContainer finds the PID of the process, among pid and its
+// descendants, that actually resides in the network namespace identified
+// by sandboxKey. pid itself is frequently a shim or wrapper process (e.g.
+// containerd-shim) that stays behind in the host namespace, so it isn't
+// enough on its own to identify which process is really inside the
+// sandbox.
+func GetPidForContainer(pid int, sandboxKey string) (int, error) {
+	return procfs.FindDescendantInNamespace(pid, sandboxKey)
+}
+
+// Pid reverse-maps n back to a live process residing in it. The PID that
+// originally created the namespace may be long gone (it may not even
+// have been a libnetwork-managed PID, e.g. after NewSandboxFromPid), so
+// this scans /proc rather than remembering a single PID.
+func (n *networkNamespace) Pid() (int, error) {
+	return procfs.FindPidInNamespace(n.path)
+}
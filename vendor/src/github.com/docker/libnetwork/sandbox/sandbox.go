@@ -0,0 +1,68 @@
+package sandbox
+
+import (
+	"net"
+)
+
+// Sandbox represents a network sandbox, identified by a unique key. It
+// holds a set of network devices, routes and other network related
+// settings that are visible only inside the sandbox (typically backed by
+// a Linux network namespace).
+type Sandbox interface {
+	// Key returns the path by which the sandbox can be joined.
+	Key() string
+
+	// Pid returns the process id of a live process currently residing in
+	// this sandbox's namespace, or an error if none could be found.
+	Pid() (int, error)
+
+	// AddInterface adds an existing Interface to this sandbox.
+	AddInterface(*Interface) error
+
+	// RemoveInterface removes an existing Interface from this sandbox.
+	RemoveInterface(*Interface) error
+
+	// Interfaces returns the collection of Interface previously added
+	// to this sandbox.
+	Interfaces() []*Interface
+
+	// Info returns the sandbox's current state.
+	Info() *Info
+
+	// Destroy destroys the sandbox.
+	Destroy() error
+}
+
+// Interface represents the settings and identity of a network device. It
+// is stitched together to form a complete representation of a network
+// endpoint inside a sandbox.
+type Interface struct {
+	// SrcName is the name of the interface in the origin network
+	// namespace, before it gets moved into the sandbox.
+	SrcName string
+	// DstName is the name (prefix) the interface is renamed to once
+	// inside the sandbox.
+	DstName     string
+	Master      string
+	DstMaster   string
+	Addresses   *net.IPNet
+	AddressIPv6 *net.IPNet
+	Routes      []*net.IPNet
+}
+
+// Info represents the state of a sandbox, as reported by the sandbox
+// itself.
+type Info struct {
+	Interfaces  []*Interface
+	Gateway     net.IP
+	GatewayIPv6 net.IP
+}
+
+// InterfaceStatistics represents the network statistics of a sandbox's
+// Interface.
+type InterfaceStatistics struct {
+	RxBytes, TxBytes     uint64
+	RxPackets, TxPackets uint64
+	RxErrors, TxErrors   uint64
+	RxDropped, TxDropped uint64
+}
@@ -4,13 +4,11 @@ import (
 	"net"
 	"os"
 	"path/filepath"
-	"runtime"
 	"testing"
 	"time"
 
 	"github.com/docker/libnetwork/netutils"
 	"github.com/vishvananda/netlink"
-	"github.com/vishvananda/netns"
 )
 
 const (
@@ -40,11 +38,11 @@ func newKey(t *testing.T) (string, error) {
 	return name, nil
 }
 
-func newInfo(t *testing.T) (*Info, error) {
+func newInfo(t *testing.T, nlh *netlink.Handle) (*Info, error) {
 	veth := &netlink.Veth{
 		LinkAttrs: netlink.LinkAttrs{Name: vethName1, TxQLen: 0},
 		PeerName:  vethName2}
-	if err := netlink.LinkAdd(veth); err != nil {
+	if err := nlh.LinkAdd(veth); err != nil {
 		return nil, err
 	}
 
@@ -73,7 +71,7 @@ func newInfo(t *testing.T) (*Info, error) {
 		LinkAttrs: netlink.LinkAttrs{Name: vethName3, TxQLen: 0},
 		PeerName:  vethName4}
 
-	if err := netlink.LinkAdd(veth); err != nil {
+	if err := nlh.LinkAdd(veth); err != nil {
 		return nil, err
 	}
 
@@ -104,40 +102,23 @@ func newInfo(t *testing.T) (*Info, error) {
 	return sinfo, nil
 }
 
-func verifySandbox(t *testing.T, s Sandbox) {
+func verifySandbox(t *testing.T, s Sandbox, nlh *netlink.Handle) {
 	_, ok := s.(*networkNamespace)
 	if !ok {
 		t.Fatalf("The sandox interface returned is not of type networkNamespace")
 	}
 
-	origns, err := netns.Get()
-	if err != nil {
-		t.Fatalf("Could not get the current netns: %v", err)
-	}
-	defer origns.Close()
-
-	f, err := os.OpenFile(s.Key(), os.O_RDONLY, 0)
-	if err != nil {
-		t.Fatalf("Failed top open network namespace path %q: %v", s.Key(), err)
-	}
-	defer f.Close()
-
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
-
-	nsFD := f.Fd()
-	if err = netns.Set(netns.NsHandle(nsFD)); err != nil {
-		t.Fatalf("Setting to the namespace pointed to by the sandbox %s failed: %v", s.Key(), err)
+	if _, err := os.Stat(s.Key()); err != nil {
+		t.Fatalf("Failed to stat network namespace path %q: %v", s.Key(), err)
 	}
-	defer netns.Set(origns)
 
-	_, err = netlink.LinkByName(sboxIfaceName + "0")
+	_, err := nlh.LinkByName(sboxIfaceName + "0")
 	if err != nil {
 		t.Fatalf("Could not find the interface %s inside the sandbox: %v", sboxIfaceName,
 			err)
 	}
 
-	_, err = netlink.LinkByName(sboxIfaceName + "1")
+	_, err = nlh.LinkByName(sboxIfaceName + "1")
 	if err != nil {
 		t.Fatalf("Could not find the interface %s inside the sandbox: %v", sboxIfaceName,
 			err)
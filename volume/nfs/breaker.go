@@ -0,0 +1,67 @@
+package nfsvolumedriver
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// breakerFailureThreshold is how many consecutive mount timeouts
+	// against the same server trip the breaker open.
+	breakerFailureThreshold = 3
+	// breakerCooldown is how long a tripped breaker stays open before
+	// Mount is allowed to try that server again.
+	breakerCooldown = 2 * time.Minute
+)
+
+// breakerState tracks one (server, export)'s recent mount failures.
+type breakerState struct {
+	consecutiveFailures int
+	openUntil           time.Time
+}
+
+// circuitBreaker fails Mount fast for a server that has timed out
+// breakerFailureThreshold times in a row, instead of blocking every
+// subsequent caller for mountTimeout while a down NFS server is rediscovered.
+type circuitBreaker struct {
+	mu     sync.Mutex
+	states map[string]*breakerState
+}
+
+var breakers = &circuitBreaker{states: make(map[string]*breakerState)}
+
+func breakerKey(server, export string) string {
+	return server + ":" + export
+}
+
+// allow reports whether a mount attempt for key should proceed.
+func (b *circuitBreaker) allow(key string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s, ok := b.states[key]
+	if !ok {
+		return true
+	}
+	return time.Now().After(s.openUntil)
+}
+
+func (b *circuitBreaker) recordSuccess(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.states, key)
+}
+
+func (b *circuitBreaker) recordFailure(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s, ok := b.states[key]
+	if !ok {
+		s = &breakerState{}
+		b.states[key] = s
+	}
+	s.consecutiveFailures++
+	if s.consecutiveFailures >= breakerFailureThreshold {
+		s.openUntil = time.Now().Add(breakerCooldown)
+	}
+}
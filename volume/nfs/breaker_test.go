@@ -0,0 +1,64 @@
+package nfsvolumedriver
+
+import "testing"
+
+func TestBreakerAllowsByDefault(t *testing.T) {
+	b := &circuitBreaker{states: make(map[string]*breakerState)}
+	if !b.allow("s:/e") {
+		t.Error("a key with no recorded failures should be allowed")
+	}
+}
+
+func TestBreakerTripsAfterThreshold(t *testing.T) {
+	b := &circuitBreaker{states: make(map[string]*breakerState)}
+	key := "s:/e"
+
+	for i := 0; i < breakerFailureThreshold-1; i++ {
+		b.recordFailure(key)
+		if !b.allow(key) {
+			t.Fatalf("breaker tripped after only %d failures, want %d", i+1, breakerFailureThreshold)
+		}
+	}
+
+	b.recordFailure(key)
+	if b.allow(key) {
+		t.Fatalf("breaker did not trip after %d consecutive failures", breakerFailureThreshold)
+	}
+}
+
+func TestBreakerRecordSuccessResets(t *testing.T) {
+	b := &circuitBreaker{states: make(map[string]*breakerState)}
+	key := "s:/e"
+
+	for i := 0; i < breakerFailureThreshold; i++ {
+		b.recordFailure(key)
+	}
+	if b.allow(key) {
+		t.Fatal("expected breaker to be tripped before recordSuccess")
+	}
+
+	b.recordSuccess(key)
+	if !b.allow(key) {
+		t.Fatal("recordSuccess should clear the breaker's state entirely")
+	}
+}
+
+func TestBreakerKeysAreIndependent(t *testing.T) {
+	b := &circuitBreaker{states: make(map[string]*breakerState)}
+
+	for i := 0; i < breakerFailureThreshold; i++ {
+		b.recordFailure("server-a:/e")
+	}
+	if b.allow("server-a:/e") {
+		t.Error("server-a should be tripped")
+	}
+	if !b.allow("server-b:/e") {
+		t.Error("server-b's breaker should be unaffected by server-a's failures")
+	}
+}
+
+func TestBreakerKeyFormat(t *testing.T) {
+	if got, want := breakerKey("server", "/export"), "server:/export"; got != want {
+		t.Errorf("breakerKey() = %q, want %q", got, want)
+	}
+}
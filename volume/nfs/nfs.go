@@ -1,28 +1,70 @@
 package nfsvolumedriver
 
 import (
-//	"errors"
+	//	"errors"
+	"context"
 	"fmt"
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/docker/volume"
-	"github.com/opencontainers/runc/libcontainer"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 const (
 	NFS_MOUNTS_DIRECTORY             = "/var/lib/docker/nfs_mounts"
 	NFS_MOUNTS_DIRECTORY_PERMISSIONS = 0755
+
+	// defaultTimeo is the default NFS request timeout, in deciseconds, used
+	// when the caller doesn't supply one. It, together with defaultRetry,
+	// keeps a hung NFS server from hanging the Docker daemon for longer
+	// than ~30s.
+	defaultTimeo = "30"
+	// defaultRetry is the default mount.nfs retry count used when the
+	// caller doesn't supply one: zero retries, so a server that's down
+	// fails the mount quickly instead of retrying against it for minutes.
+	defaultRetry = "0"
+
+	// mountTimeout bounds how long Mount() will wait for mount.nfs before
+	// giving up on it, since timeo/retry=0 bound the NFS protocol exchange
+	// but not a mount(2) syscall wedged on a server that's gone away.
+	mountTimeout = 35 * time.Second
 )
 
+var validVersions = map[string]bool{
+	"3":   true,
+	"4.1": true,
+	"4.2": true,
+}
+
+var validSec = map[string]bool{
+	"sys":   true,
+	"krb5":  true,
+	"krb5i": true,
+	"krb5p": true,
+}
+
 func New() *Root {
-	return &Root{}
+	r := &Root{
+		volumes: make(map[string]*Volume),
+	}
+	ensureNfsDirectoryExists()
+	if err := r.restore(); err != nil {
+		logrus.Errorf("nfs: failed to restore volumes from %s: %v", NFS_MOUNTS_DIRECTORY, err)
+	}
+	return r
 }
 
 type Root struct {
 	m sync.Mutex
+
+	// volumes holds every volume this Root knows about, keyed by name,
+	// including ones reattached by restore() after a daemon restart.
+	volumes map[string]*Volume
 }
 
 func (r *Root) Name() string {
@@ -41,22 +83,251 @@ func ensureNfsDirectoryExists() error {
 	return os.MkdirAll(NFS_MOUNTS_DIRECTORY, NFS_MOUNTS_DIRECTORY_PERMISSIONS)
 }
 
-func (r *Root) Create(name string, _ map[string]string) (volume.Volume, error) {
+func (r *Root) Create(name string, opts map[string]string) (volume.Volume, error) {
 	r.m.Lock()
 	defer r.m.Unlock()
 
+	if v, exists := r.volumes[name]; exists {
+		// Docker calls Create for volumes it already knows about (e.g. on
+		// daemon startup), so this must be idempotent. A volume restored
+		// from its state file on disk is returned as-is.
+		return v, nil
+	}
+
+	options, err := parseOptions(name, opts)
+	if err != nil {
+		return nil, err
+	}
+
 	ensureNfsDirectoryExists()
-	return &Volume{
+	v := &Volume{
 		driverName: r.Name(),
 		name:       name,
-	}, nil
+		options:    options,
+	}
+	r.volumes[name] = v
+	return v, nil
 }
 
 func (r *Root) Remove(v volume.Volume) error {
-	// Nothing to do
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	delete(r.volumes, v.Name())
 	return nil
 }
 
+// MountOptions holds the parsed, validated set of options a volume was
+// created with, and knows how to render itself into the "-o" argument
+// that is passed down to mount.nfs.
+type MountOptions struct {
+	// Server is the NFS server to mount from. If not given via the
+	// "server" opt, it is derived from the volume name (server:/export).
+	Server string
+	// Export is the remote path exported by Server.
+	Export string
+
+	Version  string // "3", "4.1" or "4.2"
+	ReadOnly bool
+	Sec      string // sys, krb5, krb5i or krb5p
+	Soft     bool
+	Hard     bool
+	RSize    int
+	WSize    int
+	Timeo    int
+	Retrans  int
+	Port     int
+
+	// Retry is the mount.nfs retry count. A pointer so that an explicit
+	// "retry=0" can be told apart from the option not being given at all;
+	// nil means "use defaultRetry".
+	Retry *int
+
+	// MountOpts is a raw, comma-separated list of additional "-o"
+	// options passed through untouched, for anything this driver
+	// doesn't know how to validate itself.
+	MountOpts string
+}
+
+// Source returns the "server:/export" string mount.nfs expects.
+func (o *MountOptions) Source() string {
+	return o.Server + ":" + o.Export
+}
+
+// Args renders the option set into the "-o" argument passed to the mount
+// command, e.g. []string{"-o", "retry=0,vers=4.1,timeo=30,..."}.
+func (o *MountOptions) Args() []string {
+	retry := defaultRetry
+	if o.Retry != nil {
+		retry = strconv.Itoa(*o.Retry)
+	}
+	opts := []string{"retry=" + retry}
+
+	if o.Version != "" {
+		opts = append(opts, "vers="+o.Version)
+	}
+	if o.ReadOnly {
+		opts = append(opts, "ro")
+	}
+	if o.Sec != "" {
+		opts = append(opts, "sec="+o.Sec)
+	}
+	if o.Soft {
+		opts = append(opts, "soft")
+	} else if o.Hard {
+		opts = append(opts, "hard")
+	}
+	if o.RSize != 0 {
+		opts = append(opts, "rsize="+strconv.Itoa(o.RSize))
+	}
+	if o.WSize != 0 {
+		opts = append(opts, "wsize="+strconv.Itoa(o.WSize))
+	}
+	timeo := defaultTimeo
+	if o.Timeo != 0 {
+		timeo = strconv.Itoa(o.Timeo)
+	}
+	opts = append(opts, "timeo="+timeo)
+	if o.Retrans != 0 {
+		opts = append(opts, "retrans="+strconv.Itoa(o.Retrans))
+	}
+	if o.Port != 0 {
+		opts = append(opts, "port="+strconv.Itoa(o.Port))
+	}
+	if o.MountOpts != "" {
+		opts = append(opts, o.MountOpts)
+	}
+
+	return []string{"-o", strings.Join(opts, ",")}
+}
+
+// parseOptions validates the opts map passed to Create and turns it into a
+// MountOptions. When "server"/"export" aren't given explicitly, they are
+// derived from name in the legacy "server:/export" form, to keep existing
+// volume names working.
+func parseOptions(name string, opts map[string]string) (*MountOptions, error) {
+	o := &MountOptions{
+		Server: opts["server"],
+		Export: opts["export"],
+	}
+
+	if o.Server == "" || o.Export == "" {
+		server, export, err := splitNameIntoServerExport(name)
+		if err != nil {
+			return nil, err
+		}
+		if o.Server == "" {
+			o.Server = server
+		}
+		if o.Export == "" {
+			o.Export = export
+		}
+	}
+	if o.Server == "" {
+		return nil, fmt.Errorf("nfs: missing required option 'server'")
+	}
+	if o.Export == "" {
+		return nil, fmt.Errorf("nfs: missing required option 'export'")
+	}
+
+	if v, ok := opts["version"]; ok {
+		if !validVersions[v] {
+			return nil, fmt.Errorf("nfs: invalid version %q, must be one of 3, 4.1, 4.2", v)
+		}
+		o.Version = v
+	}
+
+	if ro, ok := opts["ro"]; ok {
+		b, err := strconv.ParseBool(ro)
+		if err != nil {
+			return nil, fmt.Errorf("nfs: invalid value for 'ro': %v", err)
+		}
+		o.ReadOnly = b
+	}
+
+	if sec, ok := opts["sec"]; ok {
+		if !validSec[sec] {
+			return nil, fmt.Errorf("nfs: invalid sec %q, must be one of sys, krb5, krb5i, krb5p", sec)
+		}
+		o.Sec = sec
+	}
+
+	if _, ok := opts["soft"]; ok {
+		o.Soft = true
+	}
+	if _, ok := opts["hard"]; ok {
+		o.Hard = true
+	}
+	if o.Soft && o.Hard {
+		return nil, fmt.Errorf("nfs: 'soft' and 'hard' are mutually exclusive")
+	}
+
+	var err error
+	if o.RSize, err = parseUintOption(opts, "rsize"); err != nil {
+		return nil, err
+	}
+	if o.WSize, err = parseUintOption(opts, "wsize"); err != nil {
+		return nil, err
+	}
+	if o.Timeo, err = parseUintOption(opts, "timeo"); err != nil {
+		return nil, err
+	}
+	if o.Retrans, err = parseUintOption(opts, "retrans"); err != nil {
+		return nil, err
+	}
+	if o.Port, err = parseUintOption(opts, "port"); err != nil {
+		return nil, err
+	}
+	if o.Retry, err = parseUintOptionPtr(opts, "retry"); err != nil {
+		return nil, err
+	}
+
+	o.MountOpts = opts["mountopts"]
+
+	return o, nil
+}
+
+// parseUintOption parses opts[key] as a non-negative integer, returning 0
+// (meaning "unset") if the key isn't present.
+func parseUintOption(opts map[string]string, key string) (int, error) {
+	val, ok := opts[key]
+	if !ok || val == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("nfs: invalid value for %q: %q", key, val)
+	}
+	return n, nil
+}
+
+// parseUintOptionPtr is like parseUintOption, but returns nil instead of 0
+// when the key isn't present, so a field whose meaningful default is
+// itself 0 (e.g. "retry") can still tell "unset" apart from "explicitly
+// set to 0".
+func parseUintOptionPtr(opts map[string]string, key string) (*int, error) {
+	val, ok := opts[key]
+	if !ok || val == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("nfs: invalid value for %q: %q", key, val)
+	}
+	return &n, nil
+}
+
+// splitNameIntoServerExport supports the legacy volume naming convention of
+// encoding the mount source directly in the volume name, e.g.
+// "myserver:/export/path".
+func splitNameIntoServerExport(name string) (server, export string, err error) {
+	parts := strings.SplitN(name, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("nfs: volume name %q is not in 'server:/export' form and no 'server'/'export' options were given", name)
+	}
+	return parts[0], parts[1], nil
+}
+
 type Volume struct {
 	m sync.Mutex
 
@@ -68,6 +339,8 @@ type Volume struct {
 	driverName string
 	// The host directory where the nfs was mounted to
 	hostDirectory string
+	// options holds the parsed mount options this volume was created with.
+	options *MountOptions
 }
 
 func (v *Volume) Name() string {
@@ -84,6 +357,12 @@ func (v *Volume) Path() string {
 	return v.hostDirectory
 }
 
+// Options returns the mount options this volume was created with, so that
+// callers such as `docker volume inspect` can report them.
+func (v *Volume) Options() *MountOptions {
+	return v.options
+}
+
 func (v *Volume) Mount() (string, error) {
 	v.m.Lock()
 	defer v.m.Unlock()
@@ -94,19 +373,75 @@ func (v *Volume) Mount() (string, error) {
 	v.usedCount++
 	if v.usedCount > 1 {
 		// Already mounted
+		if err := v.saveState(); err != nil {
+			logrus.Errorf("nfs: failed to save state for volume %s: %v", v.Name(), err)
+		}
+		return v.hostDirectory, nil
+	}
+	if v.hostDirectory != "" {
+		// Reattached by restore() after a daemon restart: the mount is
+		// still live, so this call is a new reference to it, not a new mount.
+		if err := v.saveState(); err != nil {
+			logrus.Errorf("nfs: failed to save state for volume %s: %v", v.Name(), err)
+		}
 		return v.hostDirectory, nil
 	}
+	key := breakerKey(v.options.Server, v.options.Export)
+	if !breakers.allow(key) {
+		return "", fmt.Errorf("nfs: server %s has timed out repeatedly, refusing new mounts for a cool-down period", v.options.Server)
+	}
+
 	name, err := ioutil.TempDir(NFS_MOUNTS_DIRECTORY, "")
 	if err != nil {
 		return "", err
 	}
 	v.hostDirectory = name
-	// retry=0,timeo=30: Fail if NFS server can't be reached in 30 second (no retries) - aggressive, but necessary because the Docker daemon becomes unresponsive if the mount command hangs.
-	args := []string{"-o", "retry=0,timeo=30"}
+	// retry=0 is the aggressive default that keeps a hung NFS server from
+	// hanging the Docker daemon; like timeo, it's overridable through the
+	// options this volume was created with (see MountOptions.Args).
+	args := v.options.Args()
 
-	if err = libcontainer.DoMountCmd(v.DriverName(), v.Name(), v.hostDirectory, args); err != nil {
-		return "", err
+	// mount.nfs is run under its own context deadline, on top of
+	// timeo/retry, because a server that's gone away can still wedge the
+	// mount(2) syscall itself past what the NFS-level timeout covers.
+	// CommandContext is what makes that deadline real: when it fires, the
+	// child is killed outright rather than merely stopped-waiting-on, so a
+	// timed-out attempt can never go on to succeed in the background and
+	// leave an untracked, unreferenced mount behind.
+	ctx, cancel := context.WithTimeout(context.Background(), mountTimeout)
+	defer cancel()
+
+	mountArgs := append([]string{"-t", v.DriverName(), v.options.Source(), v.hostDirectory}, args...)
+	cmd := exec.CommandContext(ctx, "mount", mountArgs...)
+
+	mountErr := make(chan error, 1)
+	go func() {
+		mountErr <- cmd.Run()
+	}()
+
+	select {
+	case err := <-mountErr:
+		if err != nil {
+			// Not a breaker failure: this is mount.nfs rejecting the
+			// attempt outright (bad options, export access denied, ...),
+			// not the server timing out. The breaker exists to stop
+			// hammering a server that isn't responding, not to punish
+			// requests that were never going to succeed.
+			v.hostDirectory = ""
+			return "", err
+		}
+	case <-ctx.Done():
+		breakers.recordFailure(key)
+		logrus.Errorf("nfs: mount of %s for volume %s did not complete within %s, killing it", v.options.Source(), v.Name(), mountTimeout)
+		v.hostDirectory = ""
+		return "", fmt.Errorf("nfs: mount of %s timed out after %s", v.options.Source(), mountTimeout)
+	}
+
+	breakers.recordSuccess(key)
+	if err := v.saveState(); err != nil {
+		logrus.Errorf("nfs: failed to save state for volume %s: %v", v.Name(), err)
 	}
+	trackForHealthCheck(v)
 	return v.hostDirectory, nil
 }
 
@@ -120,6 +455,17 @@ func (v *Volume) Unmount() error {
 
 	// Don't unmount if still being used
 	if v.usedCount > 0 {
+		if err := v.saveState(); err != nil {
+			logrus.Errorf("nfs: failed to save state for volume %s: %v", v.Name(), err)
+		}
+		return nil
+	}
+
+	if v.hostDirectory == "" {
+		// Already torn down, e.g. evicted by the reaper while this
+		// container's reference was still outstanding. The eviction
+		// already did the umount/state cleanup; there's nothing left
+		// for this (or any further) Unmount() call to do.
 		return nil
 	}
 
@@ -133,6 +479,10 @@ func (v *Volume) Unmount() error {
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to remove directory %s\n", v.hostDirectory)
 	}
+	if err := v.removeState(); err != nil {
+		logrus.Errorf("nfs: failed to remove state for volume %s: %v", v.Name(), err)
+	}
+	untrackForHealthCheck(v.hostDirectory)
 	v.hostDirectory = ""
 	return err
 }
@@ -146,4 +496,4 @@ func (v *Volume) release() error {
 	}
 	v.usedCount--
 	return nil
-}
\ No newline at end of file
+}
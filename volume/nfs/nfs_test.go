@@ -0,0 +1,183 @@
+package nfsvolumedriver
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseOptionsServerExportFromName(t *testing.T) {
+	o, err := parseOptions("myserver:/export/path", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if o.Server != "myserver" || o.Export != "/export/path" {
+		t.Fatalf("got server=%q export=%q, want myserver, /export/path", o.Server, o.Export)
+	}
+}
+
+func TestParseOptionsServerExportFromOpts(t *testing.T) {
+	o, err := parseOptions("anything", map[string]string{
+		"server": "myserver",
+		"export": "/export/path",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if o.Server != "myserver" || o.Export != "/export/path" {
+		t.Fatalf("got server=%q export=%q, want myserver, /export/path", o.Server, o.Export)
+	}
+}
+
+func TestParseOptionsMissingServerExport(t *testing.T) {
+	if _, err := parseOptions("not-a-valid-name", nil); err == nil {
+		t.Fatal("expected an error for a name with no server:/export and no opts")
+	}
+}
+
+func TestParseOptionsInvalidVersion(t *testing.T) {
+	_, err := parseOptions("s:/e", map[string]string{"version": "2"})
+	if err == nil {
+		t.Fatal("expected an error for an invalid version")
+	}
+}
+
+func TestParseOptionsInvalidSec(t *testing.T) {
+	_, err := parseOptions("s:/e", map[string]string{"sec": "bogus"})
+	if err == nil {
+		t.Fatal("expected an error for an invalid sec")
+	}
+}
+
+func TestParseOptionsSoftAndHardMutuallyExclusive(t *testing.T) {
+	_, err := parseOptions("s:/e", map[string]string{"soft": "", "hard": ""})
+	if err == nil {
+		t.Fatal("expected an error when both soft and hard are given")
+	}
+}
+
+func TestParseOptionsInvalidUintOption(t *testing.T) {
+	for _, key := range []string{"rsize", "wsize", "timeo", "retrans", "port", "retry"} {
+		if _, err := parseOptions("s:/e", map[string]string{key: "-1"}); err == nil {
+			t.Errorf("expected an error for %s=-1", key)
+		}
+		if _, err := parseOptions("s:/e", map[string]string{key: "not-a-number"}); err == nil {
+			t.Errorf("expected an error for %s=not-a-number", key)
+		}
+	}
+}
+
+func TestParseOptionsRetryUnsetUsesDefault(t *testing.T) {
+	o, err := parseOptions("s:/e", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if o.Retry != nil {
+		t.Fatalf("expected Retry to be nil when unset, got %v", *o.Retry)
+	}
+}
+
+func TestParseOptionsRetryExplicitZero(t *testing.T) {
+	o, err := parseOptions("s:/e", map[string]string{"retry": "0"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if o.Retry == nil || *o.Retry != 0 {
+		t.Fatalf("expected Retry to be a pointer to 0 when explicitly set, got %v", o.Retry)
+	}
+}
+
+func argsString(o *MountOptions) string {
+	args := o.Args()
+	if len(args) != 2 || args[0] != "-o" {
+		return strings.Join(args, " ")
+	}
+	return args[1]
+}
+
+func TestArgsDefaults(t *testing.T) {
+	o := &MountOptions{Server: "s", Export: "/e"}
+	opts := argsString(o)
+	if !strings.Contains(opts, "retry=0") {
+		t.Errorf("expected default retry=0, got %q", opts)
+	}
+	if !strings.Contains(opts, "timeo="+defaultTimeo) {
+		t.Errorf("expected default timeo=%s, got %q", defaultTimeo, opts)
+	}
+}
+
+func TestArgsRetryOverride(t *testing.T) {
+	retry := 5
+	o := &MountOptions{Server: "s", Export: "/e", Retry: &retry}
+	opts := argsString(o)
+	if !strings.Contains(opts, "retry=5") {
+		t.Errorf("expected overridden retry=5, got %q", opts)
+	}
+}
+
+func TestArgsTimeoOverride(t *testing.T) {
+	o := &MountOptions{Server: "s", Export: "/e", Timeo: 60}
+	opts := argsString(o)
+	if !strings.Contains(opts, "timeo=60") {
+		t.Errorf("expected overridden timeo=60, got %q", opts)
+	}
+}
+
+func TestArgsIncludesEveryOption(t *testing.T) {
+	o := &MountOptions{
+		Server:    "s",
+		Export:    "/e",
+		Version:   "4.1",
+		ReadOnly:  true,
+		Sec:       "krb5",
+		Soft:      true,
+		RSize:     1024,
+		WSize:     2048,
+		Retrans:   3,
+		Port:      2049,
+		MountOpts: "extra=1",
+	}
+	opts := argsString(o)
+	for _, want := range []string{"vers=4.1", "ro", "sec=krb5", "soft", "rsize=1024", "wsize=2048", "retrans=3", "port=2049", "extra=1"} {
+		if !strings.Contains(opts, want) {
+			t.Errorf("expected args to contain %q, got %q", want, opts)
+		}
+	}
+}
+
+func TestSourceFormat(t *testing.T) {
+	o := &MountOptions{Server: "s", Export: "/e"}
+	if got, want := o.Source(), "s:/e"; got != want {
+		t.Errorf("Source() = %q, want %q", got, want)
+	}
+}
+
+func TestSplitNameIntoServerExport(t *testing.T) {
+	server, export, err := splitNameIntoServerExport("myserver:/a/b")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if server != "myserver" || export != "/a/b" {
+		t.Errorf("got %q, %q", server, export)
+	}
+
+	if _, _, err := splitNameIntoServerExport("novalidname"); err == nil {
+		t.Error("expected an error for a name with no colon")
+	}
+}
+
+func TestParseUintOptionPtrDistinguishesUnsetFromZero(t *testing.T) {
+	n, err := parseUintOptionPtr(map[string]string{}, "retry")
+	if err != nil || n != nil {
+		t.Fatalf("got (%v, %v), want (nil, nil)", n, err)
+	}
+
+	n, err = parseUintOptionPtr(map[string]string{"retry": "0"}, "retry")
+	if err != nil || n == nil || *n != 0 {
+		t.Fatalf("got (%v, %v), want (pointer to 0, nil)", n, err)
+	}
+
+	if !reflect.DeepEqual(*n, 0) {
+		t.Fatalf("expected *n == 0, got %v", *n)
+	}
+}
@@ -0,0 +1,149 @@
+package nfsvolumedriver
+
+import (
+	"github.com/Sirupsen/logrus"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	// reaperPeriod is how often mounted volumes are health-checked.
+	reaperPeriod = 30 * time.Second
+	// staleStatTimeout bounds how long a single health check will wait
+	// on stat(2) before treating the mount as unreachable; an NFS server
+	// that has gone away typically makes stat(2) hang rather than fail.
+	staleStatTimeout = 5 * time.Second
+)
+
+var (
+	reaperLock sync.Mutex
+	// tracked holds every currently-mounted Volume, keyed by hostDirectory,
+	// so the reaper can periodically check on it without needing a handle
+	// back to the Root that created it.
+	tracked = make(map[string]*Volume)
+	// reaperChan lets ReapNow trigger a health-check pass immediately
+	// instead of waiting out reaperPeriod.
+	reaperChan = make(chan chan struct{})
+)
+
+// ReapNow triggers a synchronous health-check pass of every tracked
+// volume and blocks until it completes, instead of waiting for the next
+// scheduled reaperPeriod tick. Tests use this to exercise eviction
+// without sleeping out the real period.
+func ReapNow() {
+	waitChan := make(chan struct{})
+	reaperChan <- waitChan
+	<-waitChan
+}
+
+func init() {
+	go reapStaleMounts()
+}
+
+func trackForHealthCheck(v *Volume) {
+	reaperLock.Lock()
+	tracked[v.hostDirectory] = v
+	reaperLock.Unlock()
+}
+
+func untrackForHealthCheck(hostDirectory string) {
+	reaperLock.Lock()
+	delete(tracked, hostDirectory)
+	reaperLock.Unlock()
+}
+
+// reapStaleMounts runs for the lifetime of the process, health-checking
+// every tracked mount every reaperPeriod and lazy-unmounting the ones that
+// have gone stale, so an NFS server outage shows up in the daemon log as
+// an eviction rather than as containers mysteriously hanging on I/O.
+func reapStaleMounts() {
+	for {
+		checkAllTracked()
+
+		select {
+		case <-time.After(reaperPeriod):
+		case c := <-reaperChan:
+			checkAllTracked()
+			c <- struct{}{}
+		}
+	}
+}
+
+// checkAllTracked runs evictIfStale over a snapshot of every currently
+// tracked volume.
+func checkAllTracked() {
+	reaperLock.Lock()
+	volumes := make([]*Volume, 0, len(tracked))
+	for _, v := range tracked {
+		volumes = append(volumes, v)
+	}
+	reaperLock.Unlock()
+
+	for _, v := range volumes {
+		evictIfStale(v)
+	}
+}
+
+// evictIfStale health-checks a single volume's mount and, if it's gone
+// stale, lazy-unmounts it and clears hostDirectory so a later Mount()
+// call establishes a fresh mount rather than handing out a dead
+// directory forever. usedCount is deliberately left alone: containers
+// that mounted the volume before the outage still hold references and
+// will call Unmount() for each of them later, which is what's supposed
+// to bring the count back down to zero, exactly as it would for a
+// mount that was never evicted.
+func evictIfStale(v *Volume) {
+	v.m.Lock()
+	hostDirectory := v.hostDirectory
+	v.m.Unlock()
+	if hostDirectory == "" {
+		return
+	}
+
+	if !isStale(hostDirectory) {
+		return
+	}
+
+	v.m.Lock()
+	defer v.m.Unlock()
+	if v.hostDirectory != hostDirectory {
+		// Already unmounted for real since the check above.
+		return
+	}
+
+	logrus.Errorf("nfs: volume %s at %s looks unreachable (server down?), lazy-unmounting it; %d container reference(s) remain outstanding",
+		v.Name(), hostDirectory, v.usedCount)
+
+	if err := exec.Command("umount", "-l", hostDirectory).Run(); err != nil {
+		logrus.Errorf("nfs: failed to lazy-unmount stale volume %s at %s: %v", v.Name(), hostDirectory, err)
+	}
+	if err := os.Remove(hostDirectory); err != nil && !os.IsNotExist(err) {
+		logrus.Errorf("nfs: failed to remove stale mount directory %s: %v", hostDirectory, err)
+	}
+	if err := v.removeState(); err != nil {
+		logrus.Errorf("nfs: failed to remove state for evicted volume %s: %v", v.Name(), err)
+	}
+
+	v.hostDirectory = ""
+	untrackForHealthCheck(hostDirectory)
+}
+
+// isStale stats hostDirectory with its own deadline, since a wedged NFS
+// server typically makes stat(2) hang rather than return promptly with
+// ESTALE.
+func isStale(hostDirectory string) bool {
+	done := make(chan error, 1)
+	go func() {
+		_, err := os.Stat(hostDirectory)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err != nil
+	case <-time.After(staleStatTimeout):
+		return true
+	}
+}
@@ -0,0 +1,145 @@
+package nfsvolumedriver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestVolume(t *testing.T, hostDirectory string, usedCount int) *Volume {
+	t.Helper()
+	return &Volume{
+		driverName:    "nfs",
+		name:          "testvolume",
+		hostDirectory: hostDirectory,
+		options:       &MountOptions{Server: "s", Export: "/e"},
+		usedCount:     usedCount,
+	}
+}
+
+func TestIsStaleLiveDirectory(t *testing.T) {
+	dir := t.TempDir()
+	if isStale(dir) {
+		t.Error("an existing directory should not be reported as stale")
+	}
+}
+
+func TestIsStaleMissingDirectory(t *testing.T) {
+	if !isStale(filepath.Join(t.TempDir(), "does-not-exist")) {
+		t.Error("a missing directory should be reported as stale")
+	}
+}
+
+func TestEvictIfStaleLeavesLiveMountAlone(t *testing.T) {
+	dir := t.TempDir()
+	v := newTestVolume(t, dir, 1)
+
+	evictIfStale(v)
+
+	if v.hostDirectory != dir {
+		t.Errorf("expected a live mount to be left alone, hostDirectory = %q", v.hostDirectory)
+	}
+}
+
+func TestEvictIfStaleEvictsStaleMount(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "nfs_mount")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	v := newTestVolume(t, dir, 2)
+	if err := v.saveState(); err != nil {
+		t.Fatalf("saveState: %v", err)
+	}
+
+	// Simulate the server vanishing out from under the mount.
+	if err := os.Remove(dir); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+
+	evictIfStale(v)
+
+	if v.hostDirectory != "" {
+		t.Errorf("expected stale mount to be evicted, hostDirectory = %q", v.hostDirectory)
+	}
+	if v.usedCount != 2 {
+		t.Errorf("expected usedCount to be left alone by eviction, got %d", v.usedCount)
+	}
+	if _, err := os.Stat(stateFilePath(dir)); !os.IsNotExist(err) {
+		t.Errorf("expected state file to be removed, stat err = %v", err)
+	}
+}
+
+func TestUnmountAfterEvictionNoops(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "nfs_mount")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	v := newTestVolume(t, dir, 2)
+	if err := os.Remove(dir); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+
+	evictIfStale(v)
+	if v.hostDirectory != "" {
+		t.Fatalf("precondition failed: volume was not evicted")
+	}
+
+	// Both containers that had the volume mounted before the outage now
+	// call Unmount(); neither should error or try to act on an empty path.
+	if err := v.Unmount(); err != nil {
+		t.Errorf("first post-eviction Unmount() returned %v, want nil", err)
+	}
+	if v.usedCount != 1 {
+		t.Errorf("usedCount = %d after first Unmount(), want 1", v.usedCount)
+	}
+
+	if err := v.Unmount(); err != nil {
+		t.Errorf("second post-eviction Unmount() returned %v, want nil", err)
+	}
+	if v.usedCount != 0 {
+		t.Errorf("usedCount = %d after second Unmount(), want 0", v.usedCount)
+	}
+
+	// A subsequent Mount() should behave like mounting a brand new volume,
+	// not like a volume stuck in some half-evicted state.
+	if v.hostDirectory != "" {
+		t.Errorf("hostDirectory = %q after full unmount, want empty", v.hostDirectory)
+	}
+}
+
+func TestReapNowEvictsStaleTrackedVolume(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "nfs_mount")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	v := newTestVolume(t, dir, 1)
+	trackForHealthCheck(v)
+	defer untrackForHealthCheck(dir)
+
+	if err := os.Remove(dir); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+
+	ReapNow()
+
+	v.m.Lock()
+	defer v.m.Unlock()
+	if v.hostDirectory != "" {
+		t.Errorf("expected ReapNow to evict the stale tracked volume, hostDirectory = %q", v.hostDirectory)
+	}
+}
+
+func TestReapNowLeavesLiveTrackedVolumeAlone(t *testing.T) {
+	dir := t.TempDir()
+	v := newTestVolume(t, dir, 1)
+	trackForHealthCheck(v)
+	defer untrackForHealthCheck(dir)
+
+	ReapNow()
+
+	v.m.Lock()
+	defer v.m.Unlock()
+	if v.hostDirectory != dir {
+		t.Errorf("expected a live tracked volume to survive ReapNow, hostDirectory = %q", v.hostDirectory)
+	}
+}
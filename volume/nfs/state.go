@@ -0,0 +1,257 @@
+package nfsvolumedriver
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const mountInfoPath = "/proc/self/mountinfo"
+
+// volumeState is the on-disk representation of a Volume, written next to
+// its host directory so a restarted daemon can find its way back to
+// mounts that are still alive in the kernel.
+type volumeState struct {
+	Name          string        `json:"name"`
+	HostDirectory string        `json:"hostDirectory"`
+	Options       *MountOptions `json:"options"`
+	RefCount      int           `json:"refCount"`
+}
+
+// stateFilePath returns the sidecar state file for a volume whose mount
+// point is hostDirectory, e.g. ".../nfs_mounts/123456" -> ".../nfs_mounts/123456.json".
+func stateFilePath(hostDirectory string) string {
+	return hostDirectory + ".json"
+}
+
+// saveState must be called with v.m held; it persists the volume's current
+// hostDirectory/options/refcount, overwriting any previous state file.
+func (v *Volume) saveState() error {
+	if v.hostDirectory == "" {
+		return nil
+	}
+	state := &volumeState{
+		Name:          v.name,
+		HostDirectory: v.hostDirectory,
+		Options:       v.options,
+		RefCount:      v.usedCount,
+	}
+	return writeStateFile(stateFilePath(v.hostDirectory), state)
+}
+
+// removeState must be called with v.m held; it deletes the volume's state
+// file once it has been fully unmounted.
+func (v *Volume) removeState() error {
+	if v.hostDirectory == "" {
+		return nil
+	}
+	err := os.Remove(stateFilePath(v.hostDirectory))
+	if err != nil && os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// writeStateFile serializes state and writes it atomically, so a daemon
+// crash mid-write can never leave a half-written, unparseable state file.
+func writeStateFile(path string, state *volumeState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func readStateFile(path string) (*volumeState, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	state := &volumeState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// restore walks NFS_MOUNTS_DIRECTORY for leftover state files, reconciles
+// them against what the kernel actually has mounted, and reattaches the
+// ones that are still live so that subsequent Mount() calls bump their
+// refcount instead of mounting a duplicate. Entries whose mount is no
+// longer there (or is there but has no matching state, i.e. the daemon
+// died between the mount.nfs call and saving the state file) are
+// lazy-unmounted and cleaned up.
+func (r *Root) restore() error {
+	entries, err := ioutil.ReadDir(NFS_MOUNTS_DIRECTORY)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	live, err := liveNfsMounts()
+	if err != nil {
+		return err
+	}
+
+	var states []*volumeState
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		statePath := filepath.Join(NFS_MOUNTS_DIRECTORY, entry.Name())
+		state, err := readStateFile(statePath)
+		if err != nil {
+			logrus.Errorf("nfs: discarding unreadable state file %s: %v", statePath, err)
+			os.Remove(statePath)
+			continue
+		}
+		states = append(states, state)
+	}
+
+	attach, stale := reconcileStates(states, live)
+
+	for _, state := range stale {
+		logrus.Warnf("nfs: volume %s is no longer mounted at %s, cleaning up stale state", state.Name, state.HostDirectory)
+		cleanupStaleState(state)
+	}
+
+	for _, state := range attach {
+		// usedCount starts at 0, not state.RefCount: Docker calls Mount()
+		// again for every live container still referencing this volume as
+		// part of normal restart reconciliation, and those calls must be
+		// what re-establishes the true refcount. Seeding it with the
+		// pre-crash count here would double-count those Mount() calls, and
+		// the matching Unmount() calls would then never bring it back down
+		// to zero, leaking the volume forever.
+		v := &Volume{
+			driverName:    r.Name(),
+			name:          state.Name,
+			hostDirectory: state.HostDirectory,
+			options:       state.Options,
+			usedCount:     0,
+		}
+		r.volumes[state.Name] = v
+		trackForHealthCheck(v)
+		logrus.Infof("nfs: reattached volume %s at %s (pre-restart refcount %d, will be re-established by Mount())", state.Name, state.HostDirectory, state.RefCount)
+	}
+
+	for _, hostDirectory := range findOrphanedMounts(live, attach) {
+		logrus.Warnf("nfs: found orphaned nfs mount %s with no state file, cleaning up", hostDirectory)
+		cleanupStaleState(&volumeState{HostDirectory: hostDirectory})
+	}
+
+	return nil
+}
+
+// reconcileStates splits states into those whose mount the kernel still
+// has live and those that are stale, by checking each one's
+// HostDirectory against live. It does no I/O, so it can be tested
+// without real mounts.
+func reconcileStates(states []*volumeState, live map[string]bool) (attach, stale []*volumeState) {
+	for _, state := range states {
+		if live[state.HostDirectory] {
+			attach = append(attach, state)
+		} else {
+			stale = append(stale, state)
+		}
+	}
+	return attach, stale
+}
+
+// findOrphanedMounts returns every entry in live that isn't accounted for
+// by attach, i.e. a real kernel mount under NFS_MOUNTS_DIRECTORY with no
+// state file pointing at it (the daemon died between the mount.nfs call
+// and saving the state file, or the state file itself was lost).
+func findOrphanedMounts(live map[string]bool, attach []*volumeState) []string {
+	attached := make(map[string]bool, len(attach))
+	for _, state := range attach {
+		attached[state.HostDirectory] = true
+	}
+
+	var orphans []string
+	for hostDirectory := range live {
+		if !attached[hostDirectory] {
+			orphans = append(orphans, hostDirectory)
+		}
+	}
+	return orphans
+}
+
+// cleanupStaleState lazy-unmounts and removes a mount/state pair that no
+// longer corresponds to anything Mount()/Unmount() will ever touch again.
+func cleanupStaleState(state *volumeState) {
+	if err := exec.Command("umount", "-l", state.HostDirectory).Run(); err != nil {
+		logrus.Errorf("nfs: failed to lazy-unmount stale mount %s: %v", state.HostDirectory, err)
+	}
+	if err := os.Remove(state.HostDirectory); err != nil && !os.IsNotExist(err) {
+		logrus.Errorf("nfs: failed to remove stale mount directory %s: %v", state.HostDirectory, err)
+	}
+	if err := os.Remove(stateFilePath(state.HostDirectory)); err != nil && !os.IsNotExist(err) {
+		logrus.Errorf("nfs: failed to remove stale state file for %s: %v", state.HostDirectory, err)
+	}
+}
+
+// liveNfsMounts returns the set of mount points under NFS_MOUNTS_DIRECTORY
+// that the kernel currently has mounted, parsed from /proc/self/mountinfo.
+func liveNfsMounts() (map[string]bool, error) {
+	f, err := os.Open(mountInfoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	live, err := parseLiveMounts(f)
+	if err != nil {
+		return nil, fmt.Errorf("nfs: failed to parse %s: %v", mountInfoPath, err)
+	}
+	return live, nil
+}
+
+// parseLiveMounts extracts the set of mount points directly under
+// NFS_MOUNTS_DIRECTORY from mountinfo-formatted data (the contents of
+// /proc/self/mountinfo). Kept separate from liveNfsMounts so the parsing
+// itself can be tested without real mounts.
+func parseLiveMounts(r io.Reader) (map[string]bool, error) {
+	live := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// Format: 36 35 98:0 /mnt1 /mnt2 rw,noatime ... - ext3 /dev/root rw,errors=continue
+		// Field 5 (0-indexed 4) is the mount point.
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		mountPoint := fields[4]
+		if filepath.Dir(mountPoint) == NFS_MOUNTS_DIRECTORY {
+			live[mountPoint] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return live, nil
+}
@@ -0,0 +1,114 @@
+package nfsvolumedriver
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteReadStateFileRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "123456.json")
+
+	want := &volumeState{
+		Name:          "myvolume",
+		HostDirectory: filepath.Join(dir, "123456"),
+		Options:       &MountOptions{Server: "s", Export: "/e"},
+		RefCount:      2,
+	}
+
+	if err := writeStateFile(path, want); err != nil {
+		t.Fatalf("writeStateFile: %v", err)
+	}
+
+	got, err := readStateFile(path)
+	if err != nil {
+		t.Fatalf("readStateFile: %v", err)
+	}
+	if got.Name != want.Name || got.HostDirectory != want.HostDirectory || got.RefCount != want.RefCount {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	if got.Options == nil || got.Options.Server != "s" || got.Options.Export != "/e" {
+		t.Fatalf("options did not round-trip: %+v", got.Options)
+	}
+}
+
+func TestReconcileStatesSplitsLiveFromStale(t *testing.T) {
+	live := map[string]bool{"/mnt/a": true}
+	states := []*volumeState{
+		{Name: "live", HostDirectory: "/mnt/a"},
+		{Name: "stale", HostDirectory: "/mnt/b"},
+	}
+
+	attach, stale := reconcileStates(states, live)
+
+	if len(attach) != 1 || attach[0].Name != "live" {
+		t.Errorf("attach = %+v, want just the live state", attach)
+	}
+	if len(stale) != 1 || stale[0].Name != "stale" {
+		t.Errorf("stale = %+v, want just the stale state", stale)
+	}
+}
+
+func TestReconcileStatesNoLiveMounts(t *testing.T) {
+	states := []*volumeState{{Name: "a", HostDirectory: "/mnt/a"}}
+	attach, stale := reconcileStates(states, map[string]bool{})
+	if len(attach) != 0 {
+		t.Errorf("expected no attaches with no live mounts, got %+v", attach)
+	}
+	if len(stale) != 1 {
+		t.Errorf("expected the one state to be stale, got %+v", stale)
+	}
+}
+
+func TestFindOrphanedMounts(t *testing.T) {
+	live := map[string]bool{"/mnt/a": true, "/mnt/b": true}
+	attach := []*volumeState{{HostDirectory: "/mnt/a"}}
+
+	orphans := findOrphanedMounts(live, attach)
+	if len(orphans) != 1 || orphans[0] != "/mnt/b" {
+		t.Errorf("orphans = %v, want [/mnt/b]", orphans)
+	}
+}
+
+func TestFindOrphanedMountsNoneWhenAllAccountedFor(t *testing.T) {
+	live := map[string]bool{"/mnt/a": true}
+	attach := []*volumeState{{HostDirectory: "/mnt/a"}}
+
+	orphans := findOrphanedMounts(live, attach)
+	if len(orphans) != 0 {
+		t.Errorf("expected no orphans, got %v", orphans)
+	}
+}
+
+func TestParseLiveMounts(t *testing.T) {
+	data := strings.Join([]string{
+		"36 35 98:0 / " + NFS_MOUNTS_DIRECTORY + "/abc123 rw,relatime shared:1 - nfs4 s:/e rw",
+		"37 35 98:0 / /some/other/mount rw,relatime shared:1 - ext4 /dev/sda1 rw",
+		"not enough fields",
+	}, "\n")
+
+	live, err := parseLiveMounts(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !live[NFS_MOUNTS_DIRECTORY+"/abc123"] {
+		t.Errorf("expected %s/abc123 to be live, got %v", NFS_MOUNTS_DIRECTORY, live)
+	}
+	if live["/some/other/mount"] {
+		t.Errorf("mount outside %s should not be reported as live", NFS_MOUNTS_DIRECTORY)
+	}
+	if len(live) != 1 {
+		t.Errorf("expected exactly one live mount, got %v", live)
+	}
+}
+
+func TestParseLiveMountsEmpty(t *testing.T) {
+	live, err := parseLiveMounts(strings.NewReader(""))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(live) != 0 {
+		t.Errorf("expected no live mounts, got %v", live)
+	}
+}